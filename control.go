@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// PhoneInfo is the last-known location and identity of a phone, learned
+// passively from the Action URL events it sends us. IP is always the
+// connecting client's actual address (ActionEvent.SourceIP), never the
+// phone's self-reported `ip` query param, since the latter is
+// attacker-controllable and IP is used to issue outbound control requests.
+type PhoneInfo struct {
+	MAC        string
+	IP         string
+	Model      string
+	Firmware   string
+	CustomerID string
+	LastSeen   time.Time
+}
+
+// PhoneRegistry tracks the last-seen IP/model/firmware of every phone that
+// has reported an ActionEvent, keyed by MAC, so the control plane knows
+// where to send commands.
+type PhoneRegistry struct {
+	mu     sync.RWMutex
+	phones map[string]*PhoneInfo
+}
+
+// NewPhoneRegistry initializes an empty PhoneRegistry.
+func NewPhoneRegistry() *PhoneRegistry {
+	return &PhoneRegistry{phones: make(map[string]*PhoneInfo)}
+}
+
+// Observe records or refreshes a phone's last-seen details from an inbound
+// ActionEvent.
+func (r *PhoneRegistry) Observe(event ActionEvent) {
+	if event.MAC == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.phones[event.MAC] = &PhoneInfo{
+		MAC:        event.MAC,
+		IP:         event.SourceIP,
+		Model:      event.Model,
+		Firmware:   event.Firmware,
+		CustomerID: event.CustomerID,
+		LastSeen:   event.Timestamp,
+	}
+}
+
+// Get returns the last-known info for a MAC, if we've seen it.
+func (r *PhoneRegistry) Get(mac string) (PhoneInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	phone, ok := r.phones[mac]
+	if !ok {
+		return PhoneInfo{}, false
+	}
+	return *phone, true
+}
+
+// CustomerAuth holds the HTTP Basic auth credentials used to reach a
+// customer's phones over their Action URI servlet.
+type CustomerAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// loadCustomerAuth reads per-customer phone control credentials from
+// PHONE_CONTROL_AUTH, a JSON object mapping customer ID to {username,password}.
+// Customers absent from the map are contacted without auth.
+func loadCustomerAuth() map[string]CustomerAuth {
+	raw := os.Getenv("PHONE_CONTROL_AUTH")
+	if raw == "" {
+		return nil
+	}
+	var auth map[string]CustomerAuth
+	if err := json.Unmarshal([]byte(raw), &auth); err != nil {
+		log.WithError(err).Error("failed to parse PHONE_CONTROL_AUTH; phone control will run without per-customer auth")
+		return nil
+	}
+	return auth
+}
+
+// controlRequest is the body accepted by POST /control/:mac.
+type controlRequest struct {
+	URI string `json:"uri"`
+}
+
+// handlePhoneControl pushes a Yealink Action URI (OK, VOLUME_UP, SPEAKER,
+// `*`, `Key=<code>`, DTMF, etc.) to the phone last seen at the given MAC by
+// GETing its Action URI servlet, closing the loop between the inbound
+// telemetry we record and Yealink's remote control surface.
+func handlePhoneControl(c *fiber.Ctx) error {
+	mac := c.Params("mac")
+
+	var body controlRequest
+	if err := c.BodyParser(&body); err != nil || body.URI == "" {
+		return c.Status(400).SendString("uri is required")
+	}
+
+	phone, ok := phoneRegistry.Get(mac)
+	if !ok {
+		return c.Status(404).SendString("phone not seen")
+	}
+
+	controlURL := fmt.Sprintf("http://%s/servlet?key=%s", phone.IP, url.QueryEscape(body.URI))
+
+	fields := log.Fields{
+		"mac":         phone.MAC,
+		"ip":          phone.IP,
+		"customer_id": phone.CustomerID,
+		"uri":         body.URI,
+	}
+
+	req, err := http.NewRequest("GET", controlURL, nil)
+	if err != nil {
+		lm.SendLog(lm.BuildLog("PHONE_CONTROL", "Failed to build control request for %s (%s): %v",
+			log.ErrorLevel, fieldsToMap(fields), phone.MAC, body.URI, err))
+		return c.Status(500).SendString("failed to build control request")
+	}
+	if auth, ok := customerAuth[phone.CustomerID]; ok && auth.Username != "" {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		fields["error"] = err.Error()
+		lm.SendLog(lm.BuildLog("PHONE_CONTROL", "Failed to send %s to phone %s (%s)",
+			log.ErrorLevel, fieldsToMap(fields), body.URI, phone.MAC, phone.IP))
+		return c.Status(502).SendString("failed to reach phone")
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	fields["status_code"] = resp.StatusCode
+	fields["response_body"] = string(respBody)
+	lm.SendLog(lm.BuildLog("PHONE_CONTROL", "Sent %s to phone %s (%s), response status %d",
+		log.InfoLevel, fieldsToMap(fields), body.URI, phone.MAC, phone.IP, resp.StatusCode))
+
+	return c.JSON(fiber.Map{
+		"mac":         phone.MAC,
+		"ip":          phone.IP,
+		"status_code": resp.StatusCode,
+		"response":    string(respBody),
+	})
+}
+
+// fieldsToMap adapts a logrus.Fields value to the map[string]interface{}
+// expected by LogManager.BuildLog.
+func fieldsToMap(fields log.Fields) map[string]interface{} {
+	m := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		m[k] = v
+	}
+	return m
+}
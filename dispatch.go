@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	dispatchQueueCapacity = 256
+	maxDispatchRetries    = 5
+	dispatchRetryBase     = 500 * time.Millisecond
+	dispatchRetryMax      = 30 * time.Second
+	dispatchShutdownWait  = 10 * time.Second
+)
+
+// SinkConfig is one entry of the dispatch config file.
+type SinkConfig struct {
+	Type       string `yaml:"type"` // webhook, nats, amqp
+	Filter     string `yaml:"filter,omitempty"`
+	Template   string `yaml:"template,omitempty"`
+	URL        string `yaml:"url"`
+	Subject    string `yaml:"subject,omitempty"`     // nats
+	Exchange   string `yaml:"exchange,omitempty"`    // amqp
+	RoutingKey string `yaml:"routing_key,omitempty"` // amqp
+}
+
+// dispatchConfig is the root of the YAML dispatch config file.
+type dispatchConfig struct {
+	Sinks []SinkConfig `yaml:"sinks"`
+}
+
+// dispatchTarget pairs a built Sink with its parsed filter and body template.
+type dispatchTarget struct {
+	cfg    SinkConfig
+	filter *filterExpr
+	tmpl   *template.Template
+	sink   Sink
+}
+
+// Dispatcher fans inbound ActionEvents out to configured webhook/NATS/AMQP
+// sinks in parallel with the Loki push pipeline, so events can trigger
+// downstream automation without polling Loki.
+type Dispatcher struct {
+	lm      *LogManager
+	targets []*dispatchTarget
+	queue   chan ActionEvent
+	wg      sync.WaitGroup
+}
+
+// NewDispatcher loads sinks from a YAML config file at configPath and starts
+// the dispatch worker. A missing configPath yields a no-op Dispatcher (no
+// sinks configured) rather than an error, since fan-out is optional.
+func NewDispatcher(configPath string, lm *LogManager) (*Dispatcher, error) {
+	d := &Dispatcher{
+		lm:    lm,
+		queue: make(chan ActionEvent, dispatchQueueCapacity),
+	}
+
+	if configPath == "" {
+		d.wg.Add(1)
+		go d.run()
+		return d, nil
+	}
+
+	raw, err := os.ReadFile(configPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			d.wg.Add(1)
+			go d.run()
+			return d, nil
+		}
+		return nil, err
+	}
+
+	var cfg dispatchConfig
+	if err := yaml.Unmarshal(raw, &cfg); err != nil {
+		return nil, err
+	}
+
+	for _, sinkCfg := range cfg.Sinks {
+		target, err := buildDispatchTarget(sinkCfg)
+		if err != nil {
+			log.WithError(err).WithField("type", sinkCfg.Type).Error("failed to configure dispatch sink; skipping")
+			continue
+		}
+		d.targets = append(d.targets, target)
+	}
+
+	d.wg.Add(1)
+	go d.run()
+	return d, nil
+}
+
+// buildDispatchTarget parses a sink's filter and body template and
+// constructs the underlying Sink implementation.
+func buildDispatchTarget(cfg SinkConfig) (*dispatchTarget, error) {
+	filter, err := parseFilter(cfg.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	tmplSource := cfg.Template
+	if tmplSource == "" {
+		tmplSource = defaultDispatchTemplate
+	}
+	tmpl, err := template.New("dispatch").Parse(tmplSource)
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := newSink(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dispatchTarget{cfg: cfg, filter: filter, tmpl: tmpl, sink: sink}, nil
+}
+
+// defaultDispatchTemplate renders a JSON body. Fields are substituted as
+// already-quoted JSON tokens (see escapedEvent), so the template itself
+// contains no literal quotes around them.
+const defaultDispatchTemplate = `{"event_type":{{.EventType}},"customer_id":{{.CustomerID}},"mac":{{.MAC}},"call_id":{{.CallID}}}`
+
+// escapedEvent mirrors ActionEvent's Timestamp and string fields except
+// SourceIP, each pre-encoded as a JSON string token (quotes included) via
+// jsonToken. Custom sink templates
+// are executed against this instead of the raw ActionEvent so that
+// attacker-controlled fields like EventType/CustomerID/MAC can't break out
+// of the surrounding JSON/body structure a template author writes.
+type escapedEvent struct {
+	Timestamp     string
+	MAC           string
+	IP            string
+	Model         string
+	Firmware      string
+	EventType     string
+	CustomerID    string
+	ActiveURL     string
+	ActiveUser    string
+	ActiveHost    string
+	Local         string
+	Remote        string
+	DisplayLocal  string
+	DisplayRemote string
+	CallID        string
+	CallerID      string
+	CalledNumber  string
+}
+
+// jsonToken JSON-encodes s, producing a quoted string token safe to splice
+// directly into a template's output.
+func jsonToken(s string) string {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return `""`
+	}
+	return string(data)
+}
+
+// escapeEventForTemplate builds the escapedEvent a dispatch template is
+// executed against.
+func escapeEventForTemplate(event ActionEvent) escapedEvent {
+	return escapedEvent{
+		Timestamp:     jsonToken(event.Timestamp.Format(time.RFC3339Nano)),
+		MAC:           jsonToken(event.MAC),
+		IP:            jsonToken(event.IP),
+		Model:         jsonToken(event.Model),
+		Firmware:      jsonToken(event.Firmware),
+		EventType:     jsonToken(event.EventType),
+		CustomerID:    jsonToken(event.CustomerID),
+		ActiveURL:     jsonToken(event.ActiveURL),
+		ActiveUser:    jsonToken(event.ActiveUser),
+		ActiveHost:    jsonToken(event.ActiveHost),
+		Local:         jsonToken(event.Local),
+		Remote:        jsonToken(event.Remote),
+		DisplayLocal:  jsonToken(event.DisplayLocal),
+		DisplayRemote: jsonToken(event.DisplayRemote),
+		CallID:        jsonToken(event.CallID),
+		CallerID:      jsonToken(event.CallerID),
+		CalledNumber:  jsonToken(event.CalledNumber),
+	}
+}
+
+// Dispatch enqueues event for delivery to every matching sink. The send
+// happens asynchronously so it never blocks the Fiber handler.
+func (d *Dispatcher) Dispatch(event ActionEvent) {
+	select {
+	case d.queue <- event:
+	default:
+		log.WithField("customer_id", event.CustomerID).Warn("dispatch queue is full; dropping event")
+	}
+}
+
+// run delivers queued events to every sink whose filter matches, retrying
+// each send with exponential backoff and logging failures through LogManager
+// as PHONE_DISPATCH entries.
+func (d *Dispatcher) run() {
+	defer d.wg.Done()
+	for event := range d.queue {
+		for _, target := range d.targets {
+			if !target.filter.Match(event) {
+				continue
+			}
+			d.send(target, event)
+		}
+	}
+}
+
+func (d *Dispatcher) send(target *dispatchTarget, event ActionEvent) {
+	var body bytes.Buffer
+	if err := target.tmpl.Execute(&body, escapeEventForTemplate(event)); err != nil {
+		d.logFailure(target.cfg, event, err)
+		return
+	}
+
+	delay := dispatchRetryBase
+	var lastErr error
+	for attempt := 0; attempt <= maxDispatchRetries; attempt++ {
+		if err := target.sink.Send(body.Bytes()); err != nil {
+			lastErr = err
+		} else {
+			return
+		}
+		if attempt == maxDispatchRetries {
+			break
+		}
+		time.Sleep(delay)
+		delay *= 2
+		if delay > dispatchRetryMax {
+			delay = dispatchRetryMax
+		}
+	}
+
+	d.logFailure(target.cfg, event, lastErr)
+}
+
+// logFailure records a dispatch failure through LogManager as a PHONE_DISPATCH entry.
+func (d *Dispatcher) logFailure(cfg SinkConfig, event ActionEvent, err error) {
+	if d.lm == nil {
+		return
+	}
+	fields := map[string]interface{}{
+		"sink_type":   cfg.Type,
+		"sink_url":    cfg.URL,
+		"customer_id": event.CustomerID,
+		"event_type":  event.EventType,
+		"error":       err.Error(),
+	}
+	l := d.lm.BuildLog(
+		"PHONE_DISPATCH",
+		"Failed to dispatch event (%s) for customer %s to %s sink",
+		log.ErrorLevel,
+		fields,
+		event.EventType,
+		event.CustomerID,
+		cfg.Type,
+	)
+	d.lm.SendLog(l)
+}
+
+// Close drains the dispatch queue and closes every sink, waiting at most
+// dispatchShutdownWait for in-flight sends to finish.
+func (d *Dispatcher) Close() {
+	close(d.queue)
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(dispatchShutdownWait):
+		log.Warn("dispatcher shutdown deadline exceeded; some queued events may not have been sent")
+	}
+
+	for _, target := range d.targets {
+		if err := target.sink.Close(); err != nil {
+			log.WithError(err).WithField("type", target.cfg.Type).Error("failed to close dispatch sink")
+		}
+	}
+}
+
+// filterExpr is a small `&&`-joined set of `field == "value"` /
+// `field != "value"` comparisons over ActionEvent fields — the "simple
+// expression filter" alternative to a full CEL evaluator.
+type filterExpr struct {
+	conditions []filterCondition
+}
+
+type filterCondition struct {
+	field  string
+	negate bool
+	value  string
+}
+
+// parseFilter parses expr into a filterExpr. An empty expr always matches.
+func parseFilter(expr string) (*filterExpr, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return &filterExpr{}, nil
+	}
+
+	var conditions []filterCondition
+	for _, part := range strings.Split(expr, "&&") {
+		cond, err := parseFilterCondition(part)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, cond)
+	}
+	return &filterExpr{conditions: conditions}, nil
+}
+
+func parseFilterCondition(part string) (filterCondition, error) {
+	part = strings.TrimSpace(part)
+	negate := false
+	op := "=="
+	idx := strings.Index(part, "!=")
+	if idx >= 0 {
+		negate = true
+	} else {
+		idx = strings.Index(part, "==")
+	}
+	if idx < 0 {
+		return filterCondition{}, fmt.Errorf("invalid filter condition %q: expected field == \"value\" or field != \"value\"", part)
+	}
+
+	field := strings.TrimSpace(part[:idx])
+	value := strings.TrimSpace(part[idx+len(op):])
+	value = strings.Trim(value, `"`)
+
+	return filterCondition{field: field, negate: negate, value: value}, nil
+}
+
+// Match reports whether every condition in the filter holds for event.
+func (f *filterExpr) Match(event ActionEvent) bool {
+	if f == nil {
+		return true
+	}
+	for _, cond := range f.conditions {
+		actual := filterField(event, cond.field)
+		equal := actual == cond.value
+		if equal == cond.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// filterField reads the ActionEvent field a filter condition refers to.
+func filterField(event ActionEvent, field string) string {
+	switch field {
+	case "eventType":
+		return event.EventType
+	case "customerID":
+		return event.CustomerID
+	case "mac":
+		return event.MAC
+	case "callID":
+		return event.CallID
+	default:
+		return event.AdditionalInfo[field]
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"net/http"
+	"time"
+)
+
+// Sink delivers a single rendered event body to a downstream system.
+type Sink interface {
+	Send(body []byte) error
+	Close() error
+}
+
+// newSink constructs the Sink implementation named by cfg.Type.
+func newSink(cfg SinkConfig) (Sink, error) {
+	switch cfg.Type {
+	case "webhook":
+		return newWebhookSink(cfg)
+	case "nats":
+		return newNATSSink(cfg)
+	case "amqp":
+		return newAMQPSink(cfg)
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", cfg.Type)
+	}
+}
+
+// webhookSink POSTs the rendered body to a URL.
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookSink(cfg SinkConfig) (*webhookSink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webhook sink requires url")
+	}
+	return &webhookSink{url: cfg.URL, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (s *webhookSink) Send(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *webhookSink) Close() error { return nil }
+
+// natsSink publishes the rendered body to a NATS subject.
+type natsSink struct {
+	conn    *nats.Conn
+	subject string
+}
+
+func newNATSSink(cfg SinkConfig) (*natsSink, error) {
+	if cfg.URL == "" || cfg.Subject == "" {
+		return nil, fmt.Errorf("nats sink requires url and subject")
+	}
+	conn, err := nats.Connect(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	return &natsSink{conn: conn, subject: cfg.Subject}, nil
+}
+
+func (s *natsSink) Send(body []byte) error {
+	return s.conn.Publish(s.subject, body)
+}
+
+func (s *natsSink) Close() error {
+	s.conn.Close()
+	return nil
+}
+
+// amqpSink publishes the rendered body to an AMQP exchange/routing key.
+type amqpSink struct {
+	conn       *amqp.Connection
+	channel    *amqp.Channel
+	exchange   string
+	routingKey string
+}
+
+func newAMQPSink(cfg SinkConfig) (*amqpSink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("amqp sink requires url")
+	}
+	conn, err := amqp.Dial(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to AMQP broker: %w", err)
+	}
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open AMQP channel: %w", err)
+	}
+	return &amqpSink{conn: conn, channel: channel, exchange: cfg.Exchange, routingKey: cfg.RoutingKey}, nil
+}
+
+func (s *amqpSink) Send(body []byte) error {
+	return s.channel.Publish(s.exchange, s.routingKey, false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        body,
+		Timestamp:   time.Now(),
+	})
+}
+
+func (s *amqpSink) Close() error {
+	if err := s.channel.Close(); err != nil {
+		return err
+	}
+	return s.conn.Close()
+}
@@ -4,15 +4,44 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sirupsen/logrus"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	defaultBatchSize       = 100
+	defaultBatchWait       = 5 * time.Second
+	defaultShutdownTimeout = 10 * time.Second
+	spoolFileName          = "loki_spool.jsonl"
+	maxPushRetries         = 5
+	retryBaseDelay         = 500 * time.Millisecond
+	retryMaxDelay          = 30 * time.Second
+)
+
+var (
+	lokiPushSuccessTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loki_push_success_total",
+		Help: "Total number of log lines successfully pushed to Loki.",
+	})
+	lokiPushFailureTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loki_push_failure_total",
+		Help: "Total number of log lines that could not be pushed to Loki after retries were exhausted.",
+	})
+	lokiDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loki_dropped_total",
+		Help: "Total number of log entries dropped because the log queue was full.",
+	})
+)
+
 func (lm *LogManager) LoadTemplates() {
 	templates := map[string]string{
 		"GenericError":       "An error occurred: %v",
@@ -25,12 +54,17 @@ func (lm *LogManager) LoadTemplates() {
 	}
 }
 
-// LogManager manages log templates and handles dispatching logs to Loki.
+// LogManager manages log templates and batches log messages for Loki.
 type LogManager struct {
 	Templates  map[string]string
 	LokiClient *LokiClient
 	LogChannel chan *LoggingFormat
 	wg         sync.WaitGroup
+
+	dataDir         string
+	batchSize       int
+	batchWait       time.Duration
+	shutdownTimeout time.Duration
 }
 
 // LoggingFormat represents the structure of a log message.
@@ -43,12 +77,6 @@ type LoggingFormat struct {
 	Timestamp      time.Time              `json:"timestamp,omitempty"`
 }
 
-// LogEntry represents a log entry for Loki.
-type LogEntry struct {
-	Timestamp time.Time
-	Line      string
-}
-
 // LokiPushData represents the data structure required by Loki's push API.
 type LokiPushData struct {
 	Streams []LokiStream `json:"streams"`
@@ -63,6 +91,7 @@ type LokiStream struct {
 // LokiClient handles interactions with the Loki service.
 type LokiClient struct {
 	PushURL  string
+	QueryURL string
 	Username string
 	Password string
 	Job      string
@@ -72,6 +101,7 @@ type LokiClient struct {
 // NewLokiClient initializes a new Loki client using environment variables.
 //   - LOKI_ENABLED (bool, optional, default false)
 //   - LOKI_PUSH_URL
+//   - LOKI_QUERY_URL (full URL of Loki's query_range API; no default, QueryRange returns an error when unset)
 //   - LOKI_USERNAME
 //   - LOKI_PASSWORD
 //   - LOKI_JOB
@@ -85,6 +115,7 @@ func NewLokiClient() *LokiClient {
 
 	return &LokiClient{
 		PushURL:  os.Getenv("LOKI_PUSH_URL"),
+		QueryURL: os.Getenv("LOKI_QUERY_URL"),
 		Username: os.Getenv("LOKI_USERNAME"),
 		Password: os.Getenv("LOKI_PASSWORD"),
 		Job:      os.Getenv("LOKI_JOB"),
@@ -92,60 +123,247 @@ func NewLokiClient() *LokiClient {
 	}
 }
 
-// PushLog sends a log entry to Loki.
-func (c *LokiClient) PushLog(labels map[string]string, entry LogEntry) error {
+// PushLog sends a batch of label-grouped streams to Loki in a single request,
+// retrying on network errors and 429/5xx responses with exponential backoff.
+func (c *LokiClient) PushLog(streams []LokiStream) error {
 	// Treat disabled / missing URL as a no-op.
 	if c == nil || !c.Enabled || c.PushURL == "" {
 		return nil
 	}
-
-	payload := LokiPushData{
-		Streams: []LokiStream{
-			{
-				Stream: labels,
-				Values: [][2]string{
-					{strconv.FormatInt(entry.Timestamp.UnixNano(), 10), entry.Line},
-				},
-			},
-		},
+	if len(streams) == 0 {
+		return nil
 	}
 
+	payload := LokiPushData{Streams: streams}
 	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal JSON payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", c.PushURL, bytes.NewBuffer(jsonPayload))
-	if err != nil {
-		return fmt.Errorf("failed to create HTTP request: %w", err)
+	client := &http.Client{Timeout: 10 * time.Second}
+	delay := retryBaseDelay
+
+	var lastErr error
+	for attempt := 0; attempt <= maxPushRetries; attempt++ {
+		req, err := http.NewRequest("POST", c.PushURL, bytes.NewReader(jsonPayload))
+		if err != nil {
+			return fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if c.Username != "" && c.Password != "" {
+			req.SetBasicAuth(c.Username, c.Password)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to send request to Loki: %w", err)
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+				// Non-retryable client error.
+				return fmt.Errorf("unexpected response from Loki: %d", resp.StatusCode)
+			}
+			lastErr = fmt.Errorf("unexpected response from Loki: %d", resp.StatusCode)
+		}
+
+		if attempt == maxPushRetries {
+			break
+		}
+		time.Sleep(delay)
+		delay *= 2
+		if delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+	}
+
+	return lastErr
+}
+
+// maxQueryPageSize bounds how many lines QueryRange asks Loki for in a single
+// query_range request; larger limits are served by paginating the cursor.
+const maxQueryPageSize = 1000
+
+// lokiQueryRangeResponse mirrors the subset of Loki's /loki/api/v1/query_range
+// response shape that QueryRange needs.
+type lokiQueryRangeResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		Result []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// QueryRange reads back ActionEvents previously pushed to Loki by calling
+// /loki/api/v1/query_range, paginating forward by advancing the start cursor
+// past the last returned entry's nanosecond timestamp until limit is reached
+// or Loki returns no further entries.
+func (c *LokiClient) QueryRange(query string, since, until time.Duration, limit int) ([]ActionEvent, error) {
+	if c == nil || !c.Enabled || c.QueryURL == "" {
+		return nil, fmt.Errorf("loki query API is not configured")
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	if c.Username != "" && c.Password != "" {
-		req.SetBasicAuth(c.Username, c.Password)
+	now := time.Now()
+	start := now.Add(-since)
+	end := now
+	if until > 0 {
+		end = now.Add(-until)
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send request to Loki: %w", err)
+	client := &http.Client{Timeout: 10 * time.Second}
+	events := make([]ActionEvent, 0, limit)
+
+	for len(events) < limit {
+		pageLimit := limit - len(events)
+		if pageLimit > maxQueryPageSize {
+			pageLimit = maxQueryPageSize
+		}
+
+		req, err := http.NewRequest("GET", c.QueryURL, nil)
+		if err != nil {
+			return events, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		q := req.URL.Query()
+		q.Set("query", query)
+		q.Set("start", strconv.FormatInt(start.UnixNano(), 10))
+		q.Set("end", strconv.FormatInt(end.UnixNano(), 10))
+		q.Set("limit", strconv.Itoa(pageLimit))
+		q.Set("direction", "forward")
+		req.URL.RawQuery = q.Encode()
+		if c.Username != "" && c.Password != "" {
+			req.SetBasicAuth(c.Username, c.Password)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return events, fmt.Errorf("failed to query Loki: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return events, fmt.Errorf("unexpected response from Loki query_range: %d", resp.StatusCode)
+		}
+
+		var parsed lokiQueryRangeResponse
+		err = json.NewDecoder(resp.Body).Decode(&parsed)
+		resp.Body.Close()
+		if err != nil {
+			return events, fmt.Errorf("failed to decode Loki query_range response: %w", err)
+		}
+
+		var lastTimestamp int64
+		pageCount := 0
+		for _, stream := range parsed.Data.Result {
+			for _, value := range stream.Values {
+				ts, err := strconv.ParseInt(value[0], 10, 64)
+				if err != nil {
+					continue
+				}
+				if ts > lastTimestamp {
+					lastTimestamp = ts
+				}
+				pageCount++
+				if event, ok := actionEventFromLogLine(value[1]); ok {
+					events = append(events, *event)
+					if len(events) >= limit {
+						break
+					}
+				}
+			}
+			if len(events) >= limit {
+				break
+			}
+		}
+
+		if pageCount == 0 || lastTimestamp == 0 {
+			break
+		}
+		start = time.Unix(0, lastTimestamp+1)
+		if !start.Before(end) {
+			break
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected response from Loki: %d", resp.StatusCode)
+	return events, nil
+}
+
+// actionEventFromLogLine parses a pushed log line (a LoggingFormat serialized
+// via LoggingFormat.String()) back into an ActionEvent. Only PHONE_ACTION
+// entries carry the fields built by buildLokiFieldsFromEvent.
+func actionEventFromLogLine(line string) (*ActionEvent, bool) {
+	var lf LoggingFormat
+	if err := json.Unmarshal([]byte(line), &lf); err != nil {
+		return nil, false
+	}
+	if lf.Type != "PHONE_ACTION" {
+		return nil, false
 	}
 
-	return nil
+	fields := lf.AdditionalData
+	event := &ActionEvent{
+		MAC:            lokiFieldString(fields, "mac"),
+		IP:             lokiFieldString(fields, "ip"),
+		Model:          lokiFieldString(fields, "model"),
+		Firmware:       lokiFieldString(fields, "firmware"),
+		EventType:      lokiFieldString(fields, "event_type"),
+		CustomerID:     lokiFieldString(fields, "customer_id"),
+		ActiveURL:      lokiFieldString(fields, "active_url"),
+		ActiveUser:     lokiFieldString(fields, "active_user"),
+		ActiveHost:     lokiFieldString(fields, "active_host"),
+		Local:          lokiFieldString(fields, "local"),
+		Remote:         lokiFieldString(fields, "remote"),
+		DisplayLocal:   lokiFieldString(fields, "display_local"),
+		DisplayRemote:  lokiFieldString(fields, "display_remote"),
+		CallID:         lokiFieldString(fields, "call_id"),
+		CallerID:       lokiFieldString(fields, "caller_id"),
+		CalledNumber:   lokiFieldString(fields, "called_number"),
+		AdditionalInfo: make(map[string]string),
+	}
+	if ts := lokiFieldString(fields, "timestamp"); ts != "" {
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			event.Timestamp = t
+		}
+	}
+	for k, v := range fields {
+		if s, ok := v.(string); ok && strings.HasPrefix(k, "extra_") {
+			event.AdditionalInfo[strings.TrimPrefix(k, "extra_")] = s
+		}
+	}
+
+	return event, true
+}
+
+// lokiFieldString reads a string field out of a Loki AdditionalData map,
+// tolerating absent keys.
+func lokiFieldString(fields map[string]interface{}, key string) string {
+	if fields == nil {
+		return ""
+	}
+	if s, ok := fields[key].(string); ok {
+		return s
+	}
+	return ""
 }
 
-// NewLogManager initializes a new LogManager.
-func NewLogManager(lokiClient *LokiClient) *LogManager {
+// NewLogManager initializes a new LogManager and starts its batching pipeline.
+//   - LOKI_BATCH_SIZE (int, optional, default 100): flush once this many buffered entries accumulate.
+//   - LOKI_BATCH_WAIT (duration, optional, default 5s): flush on this interval regardless of size.
+func NewLogManager(lokiClient *LokiClient, dataDir string) *LogManager {
 	lm := &LogManager{
-		Templates:  make(map[string]string),
-		LokiClient: lokiClient,
-		LogChannel: make(chan *LoggingFormat),
+		Templates:       make(map[string]string),
+		LokiClient:      lokiClient,
+		dataDir:         dataDir,
+		batchSize:       getEnvInt("LOKI_BATCH_SIZE", defaultBatchSize),
+		batchWait:       getEnvDuration("LOKI_BATCH_WAIT", defaultBatchWait),
+		shutdownTimeout: defaultShutdownTimeout,
 	}
+	// Buffered so a burst of incoming requests doesn't stall on a slow Loki push;
+	// SendLog drops (and counts) entries only once this is saturated.
+	lm.LogChannel = make(chan *LoggingFormat, lm.batchSize*4)
+
 	lm.wg.Add(1)
 	go lm.processLogChannel()
 	return lm
@@ -185,39 +403,207 @@ func (lm *LogManager) formatTemplate(templateName string, args ...interface{}) s
 	return fmt.Sprintf(template, args...)
 }
 
-// SendLog sends a log to Loki asynchronously via the log channel.
+// SendLog prints the log locally and enqueues it for batched delivery to Loki.
+// If the queue is saturated the entry is dropped (and counted) rather than
+// blocking the caller.
 func (lm *LogManager) SendLog(log *LoggingFormat) {
 	log.Print()
-	lm.LogChannel <- log
+	select {
+	case lm.LogChannel <- log:
+	default:
+		lokiDroppedTotal.Inc()
+		logrus.Warn("log queue is full; dropping log entry")
+	}
+}
+
+// labelSet is a batch of log lines bound for a single Loki stream (identical labels).
+type labelSet struct {
+	labels map[string]string
+	values [][2]string
 }
 
-// processLogChannel processes logs from the channel and sends them to Loki.
+// processLogChannel groups queued logs by label set and flushes them to Loki
+// either once batchSize entries have accumulated or every batchWait interval.
 func (lm *LogManager) processLogChannel() {
 	defer lm.wg.Done()
-	for log := range lm.LogChannel {
-		// If no Loki client is configured, just skip pushing but still Print() was already called.
-		if lm.LokiClient == nil {
-			continue
+
+	ticker := time.NewTicker(lm.batchWait)
+	defer ticker.Stop()
+
+	buffered := make(map[string]*labelSet)
+	count := 0
+
+	flush := func() {
+		if count == 0 {
+			return
 		}
+		lm.flushBatch(buffered)
+		buffered = make(map[string]*labelSet)
+		count = 0
+	}
 
-		labels := map[string]string{
-			"job":  lm.LokiClient.Job,
-			"type": log.Type,
+	for {
+		select {
+		case entry, ok := <-lm.LogChannel:
+			if !ok {
+				flush()
+				return
+			}
+			if lm.LokiClient == nil {
+				continue
+			}
+
+			labels := map[string]string{
+				"job":  lm.LokiClient.Job,
+				"type": entry.Type,
+			}
+			sig := labelSignature(labels)
+			group, exists := buffered[sig]
+			if !exists {
+				group = &labelSet{labels: labels}
+				buffered[sig] = group
+			}
+			group.values = append(group.values, [2]string{
+				strconv.FormatInt(entry.Timestamp.UnixNano(), 10),
+				entry.String(),
+			})
+			count++
+
+			if count >= lm.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
 		}
-		logLine := log.String()
-		entry := LogEntry{
-			Timestamp: log.Timestamp,
-			Line:      logLine,
+	}
+}
+
+// flushBatch pushes every buffered label group to Loki as one request,
+// spooling to disk on failure so the batch can be replayed later.
+func (lm *LogManager) flushBatch(buffered map[string]*labelSet) {
+	// With Loki disabled nothing is pushed, so nothing is counted as pushed
+	// and the spool is left for when Loki is enabled again.
+	if !lm.LokiClient.Enabled || lm.LokiClient.PushURL == "" {
+		return
+	}
+
+	streams := make([]LokiStream, 0, len(buffered))
+	lineCount := 0
+	for _, group := range buffered {
+		streams = append(streams, LokiStream{Stream: group.labels, Values: group.values})
+		lineCount += len(group.values)
+	}
+
+	if err := lm.LokiClient.PushLog(streams); err != nil {
+		if lm.LokiClient.Enabled {
+			logrus.WithError(err).Error("failed to push log batch to Loki; spooling to disk")
 		}
-		if err := lm.LokiClient.PushLog(labels, entry); err != nil {
-			// Only complain if Loki is actually enabled.
-			if lm.LokiClient.Enabled {
-				logrus.WithError(err).Error("Failed to send log to Loki")
-			}
+		lm.writeSpool(streams)
+		lokiPushFailureTotal.Add(float64(lineCount))
+		return
+	}
+
+	lokiPushSuccessTotal.Add(float64(lineCount))
+	lm.replaySpool()
+}
+
+// labelSignature builds a deterministic key for a label set so entries with
+// identical labels are grouped into the same Loki stream.
+func labelSignature(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+		b.WriteByte(',')
+	}
+	return b.String()
+}
+
+// spoolPath returns the on-disk location used to hold streams that could not
+// be pushed to Loki, so they can be replayed once it is reachable again.
+func (lm *LogManager) spoolPath() string {
+	return filepath.Join(lm.dataDir, spoolFileName)
+}
+
+// writeSpool appends failed streams to the spool file for later replay.
+func (lm *LogManager) writeSpool(streams []LokiStream) {
+	if lm.dataDir == "" {
+		return
+	}
+	if err := os.MkdirAll(lm.dataDir, os.ModePerm); err != nil {
+		logrus.WithError(err).Error("failed to create data dir for loki spool")
+		return
+	}
+
+	f, err := os.OpenFile(lm.spoolPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		logrus.WithError(err).Error("failed to open loki spool file")
+		return
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, s := range streams {
+		if err := enc.Encode(s); err != nil {
+			logrus.WithError(err).Error("failed to write stream to loki spool")
 		}
 	}
 }
 
+// replaySpool attempts to push any spooled streams now that Loki appears
+// reachable, leaving unreplayed entries in place for the next attempt.
+func (lm *LogManager) replaySpool() {
+	if lm.dataDir == "" {
+		return
+	}
+	path := lm.spoolPath()
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) == 0 {
+		return
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	var remaining []string
+	replaying := true
+
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		if !replaying {
+			remaining = append(remaining, line)
+			continue
+		}
+
+		var stream LokiStream
+		if err := json.Unmarshal([]byte(line), &stream); err != nil {
+			logrus.WithError(err).Warn("dropping corrupt loki spool line")
+			continue
+		}
+		if err := lm.LokiClient.PushLog([]LokiStream{stream}); err != nil {
+			replaying = false
+			remaining = append(remaining, line)
+			continue
+		}
+		lokiPushSuccessTotal.Add(float64(len(stream.Values)))
+	}
+
+	if len(remaining) == 0 {
+		os.Remove(path)
+		return
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(remaining, "\n")+"\n"), 0o644); err != nil {
+		logrus.WithError(err).Error("failed to rewrite loki spool file")
+	}
+}
+
 // Print outputs the log locally (stdout or logrus).
 func (lf *LoggingFormat) Print() {
 	logEntry := logrus.WithFields(logrus.Fields{
@@ -251,8 +637,46 @@ func (lf *LoggingFormat) String() string {
 	return string(data)
 }
 
-// CloseLogManager gracefully shuts down the log manager and waits for the log channel to empty.
+// CloseLogManager gracefully shuts down the log manager, draining the queue
+// until it empties or shutdownTimeout elapses, whichever comes first.
 func (lm *LogManager) CloseLogManager() {
 	close(lm.LogChannel)
-	lm.wg.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		lm.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(lm.shutdownTimeout):
+		logrus.Warn("LogManager shutdown deadline exceeded; some buffered logs may not have been pushed")
+	}
+}
+
+// getEnvInt reads an int env var with a default.
+func getEnvInt(name string, def int) int {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// getEnvDuration reads a duration env var (e.g. "5s", "500ms") with a default.
+func getEnvDuration(name string, def time.Duration) time.Duration {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return def
+	}
+	return d
 }
@@ -3,7 +3,12 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/gofiber/adaptor/v2"
 	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sagostin/yealink_actionurl/schema"
 	log "github.com/sirupsen/logrus"
 	"os"
 	"strconv"
@@ -15,12 +20,33 @@ var (
 	lm                *LogManager
 	saveToFileEnabled bool
 	dataDir           string
+	phoneRegistry     *PhoneRegistry
+	customerAuth      map[string]CustomerAuth
+	eventStore        EventStore
+	dispatcher        *Dispatcher
+
+	// yealinkUnknownEventTotal is a plain (unlabeled) counter rather than a
+	// vector keyed by event_type: eventType comes straight off the URL path
+	// and is attacker-controlled, so labeling by its raw value would let a
+	// caller mint unbounded Prometheus label series. The actual value is
+	// still visible via logs (see emitTypedActionLog).
+	yealinkUnknownEventTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "yealink_unknown_event_total",
+		Help: "Total number of action events whose eventType has no registered schema.",
+	})
 )
 
 type ActionEvent struct {
-	Timestamp      time.Time
-	MAC            string
-	IP             string
+	Timestamp time.Time
+	MAC       string
+	// IP is the phone's self-reported address (the `ip` query param), used
+	// only for logging/schema fields. It is attacker-controllable and must
+	// never be trusted for outbound control requests — see SourceIP.
+	IP string
+	// SourceIP is the actual TCP peer address the request arrived from
+	// (c.IP()). PhoneRegistry uses this, not IP, to decide where the
+	// control plane sends commands.
+	SourceIP       string
 	Model          string
 	Firmware       string
 	EventType      string
@@ -48,20 +74,43 @@ func main() {
 
 	// Init Loki client + LogManager
 	lokiClient := NewLokiClient()
-	lm = NewLogManager(lokiClient)
+	lm = NewLogManager(lokiClient, dataDir)
 	lm.LoadTemplates()
 	defer lm.CloseLogManager()
 
+	phoneRegistry = NewPhoneRegistry()
+	customerAuth = loadCustomerAuth()
+
+	disp, err := NewDispatcher(os.Getenv("DISPATCH_CONFIG"), lm)
+	if err != nil {
+		log.WithError(err).Fatal("failed to initialize dispatcher")
+	}
+	dispatcher = disp
+	defer dispatcher.Close()
+
+	if saveToFileEnabled {
+		store, err := NewEventStore(dataDir)
+		if err != nil {
+			log.WithError(err).Fatal("failed to initialize event store")
+		}
+		eventStore = store
+		defer eventStore.Close()
+	}
+
 	log.WithFields(log.Fields{
 		"loki_enabled":  lokiClient.Enabled,
 		"loki_push_url": lokiClient.PushURL,
 		"loki_job":      lokiClient.Job,
 		"save_to_file":  saveToFileEnabled,
 		"data_dir":      dataDir,
+		"event_store":   os.Getenv("EVENT_STORE"),
 	}).Info("Initialized action event logger")
 
 	app := fiber.New()
 	app.Get("/action/:customerID/:eventType", handleActionEvent)
+	app.Get("/events/:customerID", handleQueryEvents)
+	app.Post("/control/:mac", handlePhoneControl)
+	app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
 
 	log.Fatal(app.Listen(":3000"))
 }
@@ -74,6 +123,7 @@ func handleActionEvent(c *fiber.Ctx) error {
 		Timestamp:      time.Now(),
 		MAC:            c.Query("mac"),
 		IP:             c.Query("ip"),
+		SourceIP:       c.IP(),
 		Model:          c.Query("model"),
 		Firmware:       c.Query("firmware"),
 		EventType:      eventType,
@@ -99,10 +149,21 @@ func handleActionEvent(c *fiber.Ctx) error {
 		}
 	})
 
-	// Save to flat file (local audit trail), *if enabled*
+	// Track the phone's last-known location for the control plane.
+	if phoneRegistry != nil {
+		phoneRegistry.Observe(event)
+	}
+
+	// Validate against the typed schema for this event type, if one is
+	// registered, and emit a typed PHONE_ACTION_<TYPE> log alongside the
+	// generic one. Unknown event types fall through to the existing
+	// untyped behavior, counted so operators can see what's missing a schema.
+	emitTypedActionLog(&event)
+
+	// Persist to the local event store (local audit trail), *if enabled*
 	if saveToFileEnabled {
-		if err := saveToFile(event); err != nil {
-			log.WithError(err).Error("failed to save action event to file")
+		if err := eventStore.Save(event); err != nil {
+			log.WithError(err).Error("failed to save action event to event store")
 
 			// Also send error to Loki (if configured)
 			if lm != nil {
@@ -121,7 +182,14 @@ func handleActionEvent(c *fiber.Ctx) error {
 
 			return c.Status(500).SendString("Error saving event")
 		}
-	} else {
+	}
+
+	// Fan the event out to any configured webhook/NATS/AMQP sinks.
+	if dispatcher != nil {
+		dispatcher.Dispatch(event)
+	}
+
+	if !saveToFileEnabled {
 		log.WithFields(log.Fields{
 			"customer_id": event.CustomerID,
 			"event_type":  event.EventType,
@@ -145,6 +213,150 @@ func handleActionEvent(c *fiber.Ctx) error {
 	return c.SendString("Event recorded successfully")
 }
 
+const (
+	defaultQuerySince = time.Hour
+	defaultQueryLimit = 100
+)
+
+// handleQueryEvents reads back previously recorded ActionEvents for a
+// customer, turning the logger from a write-only sink into a queryable
+// backend for reviewing phone action history. By default it serves from the
+// local EventStore (indexed, no network round-trip); pass ?source=loki to
+// query Loki instead, e.g. to use ?since/?filter over a wider time window
+// than the local store retains.
+//
+//	?mac=...      filter to a single MAC (local store only)
+//	?call_id=...  filter to a single call ID (local store only)
+//	?limit=500    maximum events to return (default 100)
+//	?source=loki  query Loki instead of the local event store
+//	?since=1h     how far back to search in Loki (Go duration syntax, default 1h)
+//	?filter=...   raw LogQL query overriding the default customer selector (Loki only)
+func handleQueryEvents(c *fiber.Ctx) error {
+	customerID := c.Params("customerID")
+
+	limit := defaultQueryLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return c.Status(400).SendString("invalid limit")
+		}
+		limit = n
+	}
+
+	if c.Query("source") != "loki" && eventStore != nil {
+		events, err := eventStore.Query(EventQuery{
+			CustomerID: customerID,
+			MAC:        c.Query("mac"),
+			CallID:     c.Query("call_id"),
+			Limit:      limit,
+		})
+		if err != nil {
+			log.WithError(err).Error("failed to query local event store")
+			return c.Status(500).SendString("Error querying events")
+		}
+		return c.JSON(events)
+	}
+
+	since := defaultQuerySince
+	if v := c.Query("since"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return c.Status(400).SendString("invalid since duration")
+		}
+		since = d
+	}
+
+	query := c.Query("filter")
+	if query == "" {
+		// customer_id is nested under additional_data in the pushed JSON
+		// (see LoggingFormat.AdditionalData), not a top-level field, so it
+		// must be extracted by explicit path rather than a bare `| json`.
+		query = fmt.Sprintf(`{job=%q,type="PHONE_ACTION"} | json customer_id="additional_data.customer_id" | customer_id=%q`, lm.LokiClient.Job, customerID)
+	}
+
+	events, err := lm.LokiClient.QueryRange(query, since, 0, limit)
+	if err != nil {
+		log.WithError(err).Error("failed to query Loki for action events")
+		return c.Status(500).SendString("Error querying events")
+	}
+
+	return c.JSON(events)
+}
+
+// emitTypedActionLog validates event against its registered schema (if any),
+// coercing and logging it as PHONE_ACTION_<TYPE>. Event types with no
+// registered schema are counted in yealink_unknown_event_total.
+func emitTypedActionLog(event *ActionEvent) {
+	def, ok := schema.Lookup(event.EventType)
+	if !ok {
+		yealinkUnknownEventTotal.Inc()
+		log.WithField("event_type", event.EventType).Debug("action event has no registered schema")
+		return
+	}
+
+	params := schemaParams(event)
+	if err := schema.Validate(def, params); err != nil {
+		log.WithError(err).WithField("event_type", event.EventType).Warn("action event missing required schema fields")
+		return
+	}
+
+	typed, err := def.Build(params)
+	if err != nil {
+		log.WithError(err).WithField("event_type", event.EventType).Warn("failed to build typed action event")
+		return
+	}
+
+	if lm == nil {
+		return
+	}
+	fields := structToFields(typed)
+	fields["customer_id"] = event.CustomerID
+	l := lm.BuildLog(
+		"PHONE_ACTION_"+def.Name,
+		"Typed action event (%s) recorded for customer %s",
+		log.InfoLevel,
+		fields,
+		event.EventType,
+		event.CustomerID,
+	)
+	lm.SendLog(l)
+}
+
+// schemaParams flattens an ActionEvent's standard fields and AdditionalInfo
+// into the plain string map schema.Definition.Build expects.
+func schemaParams(event *ActionEvent) map[string]string {
+	params := map[string]string{
+		"mac":            event.MAC,
+		"ip":             event.IP,
+		"firmware":       event.Firmware,
+		"local":          event.Local,
+		"remote":         event.Remote,
+		"display_local":  event.DisplayLocal,
+		"display_remote": event.DisplayRemote,
+		"call_id":        event.CallID,
+		"caller_id":      event.CallerID,
+		"called_number":  event.CalledNumber,
+	}
+	for k, v := range event.AdditionalInfo {
+		params[k] = v
+	}
+	return params
+}
+
+// structToFields round-trips a typed schema struct through JSON to get a
+// field map suitable for LogManager.BuildLog.
+func structToFields(v interface{}) map[string]interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return map[string]interface{}{}
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return map[string]interface{}{}
+	}
+	return fields
+}
+
 func isStandardField(field string) bool {
 	standardFields := []string{
 		"mac", "ip", "model", "firmware", "active_url", "active_user", "active_host",
@@ -158,27 +370,6 @@ func isStandardField(field string) bool {
 	return false
 }
 
-func saveToFile(event ActionEvent) error {
-	// Ensure the data directory exists
-	if err := os.MkdirAll(dataDir, os.ModePerm); err != nil {
-		return err
-	}
-
-	filename := fmt.Sprintf("%s/%s_events.json", dataDir, event.CustomerID)
-	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
-	if err != nil {
-		return err
-	}
-	defer func(file *os.File) {
-		if cerr := file.Close(); cerr != nil {
-			log.Error(cerr)
-		}
-	}(file)
-
-	encoder := json.NewEncoder(file)
-	return encoder.Encode(event)
-}
-
 // buildLokiFieldsFromEvent flattens ActionEvent into a Loki-friendly fields map.
 func buildLokiFieldsFromEvent(event *ActionEvent) map[string]interface{} {
 	fields := map[string]interface{}{
@@ -187,6 +378,7 @@ func buildLokiFieldsFromEvent(event *ActionEvent) map[string]interface{} {
 		"event_type":     event.EventType,
 		"mac":            event.MAC,
 		"ip":             event.IP,
+		"source_ip":      event.SourceIP,
 		"model":          event.Model,
 		"firmware":       event.Firmware,
 		"active_url":     event.ActiveURL,
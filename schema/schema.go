@@ -0,0 +1,191 @@
+// Package schema defines typed field layouts for each Yealink Action URL
+// event type, so downstream consumers can rely on stable, coerced fields
+// instead of grepping through a flat AdditionalInfo map.
+package schema
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CallIncoming is emitted when a phone starts ringing for an inbound call.
+type CallIncoming struct {
+	Local        string `json:"local"`
+	Remote       string `json:"remote"`
+	CallID       string `json:"call_id"`
+	CallerID     string `json:"caller_id,omitempty"`
+	CalledNumber string `json:"called_number,omitempty"`
+}
+
+// CallConnected is emitted once a call is answered and media is flowing.
+type CallConnected struct {
+	Local         string `json:"local"`
+	Remote        string `json:"remote"`
+	CallID        string `json:"call_id"`
+	DisplayLocal  string `json:"display_local,omitempty"`
+	DisplayRemote string `json:"display_remote,omitempty"`
+}
+
+// CallTerminated is emitted when a call ends.
+type CallTerminated struct {
+	CallID     string `json:"call_id"`
+	Local      string `json:"local,omitempty"`
+	Remote     string `json:"remote,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+	ReasonCode int    `json:"reason_code,omitempty"`
+}
+
+// Registered is emitted when a phone completes SIP registration.
+type Registered struct {
+	MAC           string `json:"mac"`
+	IP            string `json:"ip"`
+	ExpireSeconds int    `json:"expire_seconds,omitempty"`
+}
+
+// Boot is emitted when a phone finishes booting.
+type Boot struct {
+	MAC        string `json:"mac"`
+	Firmware   string `json:"firmware,omitempty"`
+	ReasonCode int    `json:"reason_code,omitempty"`
+}
+
+// CPSuccess is emitted when a phone finishes applying provisioned config.
+type CPSuccess struct {
+	MAC string `json:"mac"`
+	URL string `json:"url,omitempty"`
+}
+
+// Definition describes how to validate and build the typed struct for one
+// event type.
+type Definition struct {
+	// Name is the stable log type suffix, e.g. "CALL_INCOMING" yields the
+	// Loki log type "PHONE_ACTION_CALL_INCOMING".
+	Name string
+	// RequiredParams lists the query params that must be present (and
+	// non-empty) before Build is called.
+	RequiredParams []string
+	// Build coerces raw query params into the event's typed struct.
+	Build func(params map[string]string) (interface{}, error)
+}
+
+var registry = map[string]Definition{
+	"call_incoming": {
+		Name:           "CALL_INCOMING",
+		RequiredParams: []string{"local", "remote", "call_id"},
+		Build: func(p map[string]string) (interface{}, error) {
+			return CallIncoming{
+				Local:        p["local"],
+				Remote:       p["remote"],
+				CallID:       p["call_id"],
+				CallerID:     p["caller_id"],
+				CalledNumber: p["called_number"],
+			}, nil
+		},
+	},
+	"call_connected": {
+		Name:           "CALL_CONNECTED",
+		RequiredParams: []string{"local", "remote", "call_id"},
+		Build: func(p map[string]string) (interface{}, error) {
+			return CallConnected{
+				Local:         p["local"],
+				Remote:        p["remote"],
+				CallID:        p["call_id"],
+				DisplayLocal:  p["display_local"],
+				DisplayRemote: p["display_remote"],
+			}, nil
+		},
+	},
+	"call_terminated": {
+		Name:           "CALL_TERMINATED",
+		RequiredParams: []string{"call_id", "duration"},
+		Build: func(p map[string]string) (interface{}, error) {
+			durationMS, err := parseSecondsToMS(p["duration"])
+			if err != nil {
+				return nil, fmt.Errorf("invalid duration: %w", err)
+			}
+			event := CallTerminated{
+				CallID:     p["call_id"],
+				Local:      p["local"],
+				Remote:     p["remote"],
+				DurationMS: durationMS,
+			}
+			if raw := p["reason_code"]; raw != "" {
+				code, err := strconv.Atoi(raw)
+				if err != nil {
+					return nil, fmt.Errorf("invalid reason_code: %w", err)
+				}
+				event.ReasonCode = code
+			}
+			return event, nil
+		},
+	},
+	"registered": {
+		Name:           "REGISTERED",
+		RequiredParams: []string{"mac", "ip"},
+		Build: func(p map[string]string) (interface{}, error) {
+			event := Registered{MAC: p["mac"], IP: p["ip"]}
+			if raw := p["expire"]; raw != "" {
+				expire, err := strconv.Atoi(raw)
+				if err != nil {
+					return nil, fmt.Errorf("invalid expire: %w", err)
+				}
+				event.ExpireSeconds = expire
+			}
+			return event, nil
+		},
+	},
+	"boot": {
+		Name:           "BOOT",
+		RequiredParams: []string{"mac"},
+		Build: func(p map[string]string) (interface{}, error) {
+			event := Boot{MAC: p["mac"], Firmware: p["firmware"]}
+			if raw := p["reason_code"]; raw != "" {
+				code, err := strconv.Atoi(raw)
+				if err != nil {
+					return nil, fmt.Errorf("invalid reason_code: %w", err)
+				}
+				event.ReasonCode = code
+			}
+			return event, nil
+		},
+	},
+	"cp_success": {
+		Name:           "CP_SUCCESS",
+		RequiredParams: []string{"mac"},
+		Build: func(p map[string]string) (interface{}, error) {
+			return CPSuccess{MAC: p["mac"], URL: p["url"]}, nil
+		},
+	},
+}
+
+// Lookup returns the Definition registered for eventType (matched
+// case-insensitively), if any.
+func Lookup(eventType string) (Definition, bool) {
+	def, ok := registry[strings.ToLower(eventType)]
+	return def, ok
+}
+
+// Validate reports an error naming every required param missing from params.
+func Validate(def Definition, params map[string]string) error {
+	var missing []string
+	for _, name := range def.RequiredParams {
+		if strings.TrimSpace(params[name]) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required params for %s: %s", def.Name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// parseSecondsToMS parses a decimal seconds value (as Yealink reports call
+// duration) into milliseconds.
+func parseSecondsToMS(raw string) (int64, error) {
+	seconds, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(seconds * 1000), nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EventQuery narrows an EventStore.Query call.
+type EventQuery struct {
+	CustomerID string
+	MAC        string
+	CallID     string
+	Limit      int
+}
+
+// EventStore persists ActionEvents and serves them back by customer, MAC or
+// call ID without needing a Loki round-trip.
+type EventStore interface {
+	Save(event ActionEvent) error
+	Query(q EventQuery) ([]ActionEvent, error)
+	Close() error
+}
+
+// NewEventStore builds the EventStore selected by the EVENT_STORE env var
+// (one of "jsonl-rotating", "sqlite", "bolt"; defaults to "jsonl-rotating")
+// and migrates any legacy flat `<dataDir>/<customerID>_events.json` files
+// into it on first run.
+func NewEventStore(dataDir string) (EventStore, error) {
+	kind := os.Getenv("EVENT_STORE")
+	if kind == "" {
+		kind = "jsonl-rotating"
+	}
+
+	var store EventStore
+	var err error
+	switch kind {
+	case "jsonl-rotating":
+		store, err = newJSONLRotatingStore(dataDir)
+	case "sqlite":
+		store, err = newSQLiteStore(dataDir)
+	case "bolt":
+		store, err = newBoltStore(dataDir)
+	default:
+		return nil, fmt.Errorf("unknown EVENT_STORE %q", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrateLegacyFiles(dataDir, store); err != nil {
+		log.WithError(err).Error("failed to migrate legacy event files into new event store")
+	}
+
+	return store, nil
+}
+
+// migrateLegacyFiles imports every `<dataDir>/<customerID>_events.json`
+// written by the old flat-file saveToFile into store, once. A marker file
+// records completion so restarts don't re-import on every boot.
+func migrateLegacyFiles(dataDir string, store EventStore) error {
+	markerPath := filepath.Join(dataDir, ".migrated")
+	if _, err := os.Stat(markerPath); err == nil {
+		return nil
+	}
+
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), "_events.json") {
+			continue
+		}
+
+		path := filepath.Join(dataDir, entry.Name())
+		f, err := os.Open(path)
+		if err != nil {
+			log.WithError(err).WithField("file", path).Error("failed to open legacy event file for migration")
+			continue
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		imported := 0
+		for scanner.Scan() {
+			var event ActionEvent
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				continue
+			}
+			if err := store.Save(event); err != nil {
+				log.WithError(err).WithField("file", path).Error("failed to import legacy event")
+				continue
+			}
+			imported++
+		}
+		f.Close()
+
+		log.WithFields(log.Fields{"file": path, "imported": imported}).Info("migrated legacy event file into event store")
+	}
+
+	return os.WriteFile(markerPath, []byte("done\n"), 0o644)
+}
+
+// reverseEvents reverses events in place. Backends that accumulate matches
+// in chronological (oldest-first) order before truncating to q.Limit use
+// this to match sqliteStore's `ORDER BY timestamp DESC`, so Query's
+// "most recent first" contract holds the same way regardless of EVENT_STORE.
+func reverseEvents(events []ActionEvent) {
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+}
+
+// gzipFile compresses src in place, replacing it with src+".gz".
+func gzipFile(src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(src + ".gz")
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gw := gzip.NewWriter(out)
+	if _, err := io.Copy(gw, in); err != nil {
+		gw.Close()
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"path/filepath"
+	"time"
+)
+
+var eventsBucket = []byte("events")
+
+// boltStore persists ActionEvents in a BoltDB file, one bucket-wide key
+// space ordered by customer + timestamp so range scans stay sequential.
+type boltStore struct {
+	db *bbolt.DB
+}
+
+// newBoltStore opens (creating if necessary) `<dataDir>/events.bolt`.
+func newBoltStore(dataDir string) (*boltStore, error) {
+	path := filepath.Join(dataDir, "events.bolt")
+	db, err := bbolt.Open(path, 0o644, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(eventsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+// boltKey orders entries by customer then timestamp so Query can do a
+// forward prefix scan instead of a full bucket walk.
+func boltKey(event ActionEvent) []byte {
+	// Fixed-width UTC text sorts byte-wise in chronological order; plain
+	// RFC3339Nano does not (it trims trailing zeros and keeps the local offset).
+	return []byte(fmt.Sprintf("%s\x00%s\x00%d", event.CustomerID, event.Timestamp.UTC().Format("2006-01-02T15:04:05.000000000Z07:00"), event.Timestamp.UnixNano()))
+}
+
+// Save stores event under a customer+timestamp ordered key.
+func (s *boltStore) Save(event ActionEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(eventsBucket).Put(boltKey(event), data)
+	})
+}
+
+// Query scans the customer's key prefix, filtering by MAC/call ID and
+// returning up to q.Limit entries, most recent first.
+func (s *boltStore) Query(q EventQuery) ([]ActionEvent, error) {
+	prefix := []byte(q.CustomerID + "\x00")
+	var matched []ActionEvent
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(eventsBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && hasPrefix(k, prefix); k, v = c.Next() {
+			var event ActionEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				continue
+			}
+			if q.MAC != "" && event.MAC != q.MAC {
+				continue
+			}
+			if q.CallID != "" && event.CallID != q.CallID {
+				continue
+			}
+			matched = append(matched, event)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if q.Limit > 0 && len(matched) > q.Limit {
+		matched = matched[len(matched)-q.Limit:]
+	}
+	reverseEvents(matched)
+	return matched, nil
+}
+
+func hasPrefix(b, prefix []byte) bool {
+	if len(b) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if b[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Close closes the underlying BoltDB file.
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
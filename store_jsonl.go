@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRotateMaxMB  = 50
+	defaultRotateMaxAge = 24 * time.Hour
+)
+
+// jsonlRotatingStore writes newline-delimited ActionEvent JSON per customer,
+// rotating (and gzipping) the active segment once it crosses a size or age
+// threshold, instead of letting one file grow forever.
+type jsonlRotatingStore struct {
+	mu           sync.Mutex
+	dataDir      string
+	rotateMaxB   int64
+	rotateMaxAge time.Duration
+	files        map[string]*jsonlSegment
+}
+
+// jsonlSegment tracks the currently-open segment file for one customer.
+type jsonlSegment struct {
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// newJSONLRotatingStore builds a jsonlRotatingStore rooted at dataDir.
+//   - EVENT_ROTATE_MAX_MB (int, optional, default 50): rotate once the active segment exceeds this size.
+//   - EVENT_ROTATE_MAX_AGE (duration, optional, default 24h): rotate once the active segment is older than this.
+func newJSONLRotatingStore(dataDir string) (*jsonlRotatingStore, error) {
+	if err := os.MkdirAll(dataDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &jsonlRotatingStore{
+		dataDir:      dataDir,
+		rotateMaxB:   int64(getEnvInt("EVENT_ROTATE_MAX_MB", defaultRotateMaxMB)) * 1024 * 1024,
+		rotateMaxAge: getEnvDuration("EVENT_ROTATE_MAX_AGE", defaultRotateMaxAge),
+		files:        make(map[string]*jsonlSegment),
+	}, nil
+}
+
+func (s *jsonlRotatingStore) segmentPath(customerID string) string {
+	return filepath.Join(s.dataDir, customerID+"_events.jsonl")
+}
+
+// Save appends event to its customer's active segment, rotating first if
+// the segment has grown too large or too old.
+func (s *jsonlRotatingStore) Save(event ActionEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seg, err := s.segmentFor(event.CustomerID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	n, err := seg.file.Write(data)
+	if err != nil {
+		return err
+	}
+	seg.size += int64(n)
+
+	if seg.size >= s.rotateMaxB || time.Since(seg.openedAt) >= s.rotateMaxAge {
+		if err := s.rotate(event.CustomerID, seg); err != nil {
+			log.WithError(err).WithField("customer_id", event.CustomerID).Error("failed to rotate event segment")
+		}
+	}
+
+	return nil
+}
+
+// segmentFor returns the open segment for a customer, opening it if needed.
+func (s *jsonlRotatingStore) segmentFor(customerID string) (*jsonlSegment, error) {
+	if seg, ok := s.files[customerID]; ok {
+		return seg, nil
+	}
+
+	path := s.segmentPath(customerID)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	seg := &jsonlSegment{file: f, size: info.Size(), openedAt: info.ModTime()}
+	s.files[customerID] = seg
+	return seg, nil
+}
+
+// rotate closes the active segment and gzips it under a timestamp suffix.
+// No new segment is opened here; the customer's next Save opens a fresh one.
+// Caller must hold s.mu.
+func (s *jsonlRotatingStore) rotate(customerID string, seg *jsonlSegment) error {
+	path := s.segmentPath(customerID)
+	if err := seg.file.Close(); err != nil {
+		return err
+	}
+	delete(s.files, customerID)
+
+	rotatedPath := path + "." + time.Now().UTC().Format("20060102T150405")
+	if err := os.Rename(path, rotatedPath); err != nil {
+		return err
+	}
+	return gzipFile(rotatedPath)
+}
+
+// Query scans the active segment (and, for completeness, gzipped rotated
+// segments) for a customer, filtering by MAC/call ID and most-recent-first limit.
+func (s *jsonlRotatingStore) Query(q EventQuery) ([]ActionEvent, error) {
+	s.mu.Lock()
+	if seg, ok := s.files[q.CustomerID]; ok {
+		seg.file.Sync()
+	}
+	s.mu.Unlock()
+
+	paths, err := s.segmentPaths(q.CustomerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []ActionEvent
+	for _, path := range paths {
+		events, err := readJSONLSegment(path)
+		if err != nil {
+			log.WithError(err).WithField("file", path).Error("failed to read event segment")
+			continue
+		}
+		for _, event := range events {
+			if q.MAC != "" && event.MAC != q.MAC {
+				continue
+			}
+			if q.CallID != "" && event.CallID != q.CallID {
+				continue
+			}
+			matched = append(matched, event)
+		}
+	}
+
+	if q.Limit > 0 && len(matched) > q.Limit {
+		matched = matched[len(matched)-q.Limit:]
+	}
+	reverseEvents(matched)
+	return matched, nil
+}
+
+// segmentPaths lists a customer's rotated (oldest-first) then active segment files.
+func (s *jsonlRotatingStore) segmentPaths(customerID string) ([]string, error) {
+	entries, err := os.ReadDir(s.dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := customerID + "_events.jsonl"
+	var rotated []string
+	active := filepath.Join(s.dataDir, prefix)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == prefix || !strings.HasPrefix(name, prefix+".") {
+			continue
+		}
+		rotated = append(rotated, filepath.Join(s.dataDir, name))
+	}
+
+	if _, err := os.Stat(active); err == nil {
+		rotated = append(rotated, active)
+	}
+	return rotated, nil
+}
+
+// readJSONLSegment reads an active (plain) or rotated (gzip) segment file.
+func readJSONLSegment(path string) ([]ActionEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var scanner *bufio.Scanner
+	if strings.HasSuffix(path, ".gz") {
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		scanner = bufio.NewScanner(gr)
+	} else {
+		scanner = bufio.NewScanner(f)
+	}
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var events []ActionEvent
+	for scanner.Scan() {
+		var event ActionEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
+
+// Close flushes and closes every open segment file.
+func (s *jsonlRotatingStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var firstErr error
+	for customerID, seg := range s.files {
+		if err := seg.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(s.files, customerID)
+	}
+	return firstErr
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	_ "modernc.org/sqlite"
+	"path/filepath"
+)
+
+// sqliteStore persists ActionEvents into a single SQLite database, indexed
+// for the lookups the query endpoint needs without a Loki round-trip.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+// newSQLiteStore opens (creating if necessary) `<dataDir>/events.db` and
+// ensures its schema and indexes exist.
+func newSQLiteStore(dataDir string) (*sqliteStore, error) {
+	path := filepath.Join(dataDir, "events.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only supports one writer at a time; event writes (one row per
+	// Save) are low-volume enough that serializing through one connection
+	// is simpler than pooling.
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS events (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			customer_id TEXT NOT NULL,
+			timestamp   TEXT NOT NULL,
+			mac         TEXT,
+			call_id     TEXT,
+			event       TEXT NOT NULL
+		)
+	`); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	for _, stmt := range []string{
+		`CREATE INDEX IF NOT EXISTS idx_events_customer_timestamp ON events (customer_id, timestamp)`,
+		`CREATE INDEX IF NOT EXISTS idx_events_mac ON events (mac)`,
+		`CREATE INDEX IF NOT EXISTS idx_events_call_id ON events (call_id)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return &sqliteStore{db: db}, nil
+}
+
+// Save inserts event into the events table.
+func (s *sqliteStore) Save(event ActionEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(
+		`INSERT INTO events (customer_id, timestamp, mac, call_id, event) VALUES (?, ?, ?, ?, ?)`,
+		// Fixed-width UTC text so ORDER BY timestamp is chronological.
+		event.CustomerID, event.Timestamp.UTC().Format("2006-01-02T15:04:05.000000000Z07:00"), event.MAC, event.CallID, string(data),
+	)
+	return err
+}
+
+// Query looks up events by customer, optionally narrowed by MAC or call ID,
+// most recent first, up to q.Limit rows.
+func (s *sqliteStore) Query(q EventQuery) ([]ActionEvent, error) {
+	query := `SELECT event FROM events WHERE customer_id = ?`
+	args := []interface{}{q.CustomerID}
+
+	if q.MAC != "" {
+		query += ` AND mac = ?`
+		args = append(args, q.MAC)
+	}
+	if q.CallID != "" {
+		query += ` AND call_id = ?`
+		args = append(args, q.CallID)
+	}
+	query += ` ORDER BY timestamp DESC`
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []ActionEvent
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var event ActionEvent
+		if err := json.Unmarshal([]byte(raw), &event); err != nil {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// Close closes the underlying database handle.
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}